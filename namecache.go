@@ -0,0 +1,168 @@
+package dnssd
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nameCacheWarmup is how long a NameCache must have been snooping traffic
+// before it can be trusted to report a name as unclaimed: long enough to
+// have seen at least one typical announcement interval go by.
+const nameCacheWarmup = 2 * time.Second
+
+// NameCache continuously snoops multicast traffic on every interface it is
+// started on and remembers which hostnames and service instance names are
+// currently claimed on the link, keyed per interface. ProbeService consults
+// it first: once the cache has been warm long enough, a candidate name that
+// hasn't been seen can skip straight to the announcement phase instead of
+// running the full three-query probe. This mirrors the per-interface cache
+// design used by presotto/go-mdns-sd, and materially reduces startup
+// latency for servers that restart frequently on a stable network.
+//
+// A NameCache records every claim it observes with no way to tell this
+// host's own announcements apart from a genuine competing host, so it must
+// only be handed to ProbeService for a name this host does not already
+// hold; ReprobeService rejects one outright (see ProbeOptions.NameCache).
+type NameCache struct {
+	mu      sync.Mutex
+	started time.Time
+	names   map[string]map[string]time.Time // iface name -> claimed name -> expiry
+}
+
+// NewNameCache returns a NameCache that has not yet started snooping.
+func NewNameCache() *NameCache {
+	return &NameCache{names: make(map[string]map[string]time.Time)}
+}
+
+// Start begins snooping conn's traffic on a background goroutine until ctx
+// is done. It may be called more than once, e.g. to add interfaces covered
+// by a second MDNSConn; the warmup clock starts on the first call.
+func (c *NameCache) Start(ctx context.Context, conn MDNSConn) {
+	c.mu.Lock()
+	if c.started.IsZero() {
+		c.started = time.Now()
+	}
+	c.mu.Unlock()
+
+	ch := conn.Read(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-ch:
+				c.observe(req.msg, req.iface)
+			}
+		}
+	}()
+}
+
+// observe records every A, AAAA and SRV record in msg against iface, keyed
+// by the record's owner name and expiring after its TTL.
+func (c *NameCache) observe(msg *dns.Msg, iface *net.Interface) {
+	if iface == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, rr := range allRecords(msg) {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA, *dns.SRV:
+			ttl := time.Duration(rr.Header().Ttl) * time.Second
+			c.add(iface.Name, rr.Header().Name, now.Add(ttl))
+		default:
+			continue
+		}
+	}
+}
+
+func (c *NameCache) add(ifaceName string, name string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.names[ifaceName] == nil {
+		c.names[ifaceName] = make(map[string]time.Time)
+	}
+	c.names[ifaceName][strings.ToLower(name)] = expiry
+}
+
+// IsWarm reports whether the cache has been snooping long enough to be
+// trusted for the skip-probe fast path.
+func (c *NameCache) IsWarm() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.started.IsZero() && time.Since(c.started) >= nameCacheWarmup
+}
+
+// Contains reports whether name is currently claimed on ifaceName, pruning
+// it first if its TTL has since expired.
+func (c *NameCache) Contains(ifaceName string, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names, ok := c.names[ifaceName]
+	if !ok {
+		return false
+	}
+
+	key := strings.ToLower(name)
+	expiry, ok := names[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(expiry) {
+		delete(names, key)
+		return false
+	}
+
+	return true
+}
+
+// Prune removes every cache entry whose TTL has expired.
+func (c *NameCache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for ifaceName, names := range c.names {
+		for name, expiry := range names {
+			if now.After(expiry) {
+				delete(names, name)
+			}
+		}
+		if len(names) == 0 {
+			delete(c.names, ifaceName)
+		}
+	}
+}
+
+// Snapshot returns the names currently claimed on ifaceName, for inspection
+// in tests.
+func (c *NameCache) Snapshot(ifaceName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names, ok := c.names[ifaceName]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	out := make([]string, 0, len(names))
+	for name, expiry := range names {
+		if now.After(expiry) {
+			continue
+		}
+		out = append(out, name)
+	}
+
+	return out
+}
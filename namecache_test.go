@@ -0,0 +1,93 @@
+package dnssd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNameCacheIsWarm(t *testing.T) {
+	c := NewNameCache()
+
+	if c.IsWarm() {
+		t.Fatal("cache should not be warm before it has started snooping")
+	}
+
+	c.mu.Lock()
+	c.started = time.Now().Add(-nameCacheWarmup)
+	c.mu.Unlock()
+
+	if !c.IsWarm() {
+		t.Fatal("cache should be warm once nameCacheWarmup has elapsed since it started")
+	}
+}
+
+func TestNameCacheContainsIsCaseInsensitiveAndExpires(t *testing.T) {
+	c := NewNameCache()
+
+	c.add("eth0", "Printer._http._tcp.local.", time.Now().Add(20*time.Millisecond))
+
+	if !c.Contains("eth0", "printer._http._tcp.local.") {
+		t.Fatal("expected a cached name to be found regardless of case")
+	}
+
+	if c.Contains("wlan0", "printer._http._tcp.local.") {
+		t.Fatal("a name cached on one interface should not be visible on another")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if c.Contains("eth0", "printer._http._tcp.local.") {
+		t.Fatal("expected the entry to have expired and been pruned on lookup")
+	}
+}
+
+func TestNameCachePrune(t *testing.T) {
+	c := NewNameCache()
+
+	c.add("eth0", "stale.local.", time.Now().Add(-time.Second))
+	c.add("eth0", "fresh.local.", time.Now().Add(time.Minute))
+
+	c.Prune()
+
+	names := c.Snapshot("eth0")
+	if len(names) != 1 || names[0] != "fresh.local." {
+		t.Fatalf("expected only fresh.local. to remain after Prune, got %v", names)
+	}
+}
+
+func TestNameCacheSnapshotUnknownInterface(t *testing.T) {
+	c := NewNameCache()
+
+	if names := c.Snapshot("eth0"); names != nil {
+		t.Fatalf("expected a nil snapshot for an interface with no entries, got %v", names)
+	}
+}
+
+func TestSkipProbe(t *testing.T) {
+	target := ifaceTarget{iface: &net.Interface{Name: "eth0"}}
+	candidate := Service{Host: "MyHost", Name: "My Instance._http._tcp.local."}
+
+	if skipProbe(nil, target, candidate) {
+		t.Fatal("should never skip probing without a name cache")
+	}
+
+	cache := NewNameCache()
+	if skipProbe(cache, target, candidate) {
+		t.Fatal("should not skip probing before the cache is warm")
+	}
+
+	cache.mu.Lock()
+	cache.started = time.Now().Add(-nameCacheWarmup)
+	cache.mu.Unlock()
+
+	if !skipProbe(cache, target, candidate) {
+		t.Fatal("should skip probing once the cache is warm and the candidate hasn't been observed")
+	}
+
+	cache.add("eth0", candidate.Hostname(), time.Now().Add(time.Minute))
+
+	if skipProbe(cache, target, candidate) {
+		t.Fatal("should not skip probing once the candidate's hostname has been observed on that interface")
+	}
+}
@@ -1,26 +1,219 @@
 package dnssd
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/brutella/dnssd/log"
 	"github.com/miekg/dns"
 	"math/rand"
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+// ProbeOptions controls optional behavior of ProbeService and ReprobeService.
+type ProbeOptions struct {
+	// UnicastResponse requests that responders answer the first two probe
+	// queries via unicast instead of multicast (RFC6762 5.4, 8.1), which
+	// reduces multicast load on the network. It defaults to false. When
+	// enabled, an extra, always-multicast probe query is sent on top of the
+	// usual three, so a responder that ignores the QU bit or whose unicast
+	// reply doesn't make it back to us still gets two chances to be seen by
+	// plain multicast - the same fallback that keeps issue #15 from
+	// recurring.
+	UnicastResponse bool
+
+	// ConflictResolver decides the replacement hostname and instance name to
+	// try after a probe conflict. Defaults to DefaultConflictResolver, which
+	// reproduces dnssd's original "-2", "-3", ... behavior.
+	ConflictResolver ConflictResolver
+
+	// OnRename, if set, is called once the probe has converged on every
+	// interface, for each of the hostname and instance name that ended up
+	// different from the one originally passed to ProbeService or
+	// ReprobeService - so callers can log the decision or persist the
+	// chosen name, preventing the counter from climbing higher on every
+	// restart. It is called synchronously, once per renamed part, from the
+	// same goroutine that ProbeService/ReprobeService returns on - never
+	// concurrently, and never for an intermediate candidate that a
+	// different interface later overruled.
+	OnRename func(kind RenameKind, original string, renamed string)
+
+	// NameCache, if set and warm, lets probing skip straight to the
+	// announcement phase on an interface where the candidate's hostname and
+	// instance name have not been passively observed. It has no notion of
+	// which observed claim is this host's own, so it must only be used with
+	// ProbeService for a candidate name this host does not already hold -
+	// never with ReprobeService, which re-verifies a name already claimed
+	// and would see the cache's record of that very claim as a conflict.
+	// ReprobeService rejects a NameCache for this reason.
+	NameCache *NameCache
+}
+
+// RenameKind identifies which part of a Service a ConflictResolver renamed.
+type RenameKind int
+
+const (
+	RenameKindHost RenameKind = iota
+	RenameKindInstance
+)
+
+func (k RenameKind) String() string {
+	switch k {
+	case RenameKindHost:
+		return "host"
+	case RenameKindInstance:
+		return "instance"
+	default:
+		return "unknown"
+	}
+}
+
+// ConflictResolver picks the hostname or service instance name to probe next
+// after a probe conflict.
+type ConflictResolver interface {
+	// RenameHost returns the hostname to try after original's current
+	// candidate lost a probe conflict. attempt is the 1-based count of
+	// hostname renames so far for this service, including those carried
+	// over from the interface adopted in earlier reconcile rounds.
+	RenameHost(original string, attempt int, conflict probeConflict) string
+
+	// RenameInstance returns the service instance name to try after
+	// original's current candidate lost a probe conflict. attempt is the
+	// 1-based count of instance name renames so far for this service,
+	// including those carried over from the interface adopted in earlier
+	// reconcile rounds.
+	RenameInstance(original string, attempt int, conflict probeConflict) string
+}
+
+// DefaultConflictResolver reproduces dnssd's original renaming behavior:
+// appending "-2", "-3", ... on every conflict.
+type DefaultConflictResolver struct{}
+
+func (DefaultConflictResolver) RenameHost(original string, attempt int, conflict probeConflict) string {
+	return fmt.Sprintf("%s-%d", original, attempt+1)
+}
+
+func (DefaultConflictResolver) RenameInstance(original string, attempt int, conflict probeConflict) string {
+	return fmt.Sprintf("%s-%d", original, attempt+1)
+}
+
+// RandomSuffixConflictResolver renames by appending a short random
+// alphanumeric token instead of an incrementing counter, so a collision
+// doesn't leave a user-visible name like a printer or speaker with an ugly
+// "-2", "-3" suffix.
+type RandomSuffixConflictResolver struct {
+	// Length is the number of random characters appended on the first
+	// attempt; each later attempt appends one more. Defaults to 4.
+	Length int
+}
+
+func (r RandomSuffixConflictResolver) RenameHost(original string, attempt int, conflict probeConflict) string {
+	return fmt.Sprintf("%s-%s", original, randomSuffix(r.length()+attempt-1))
+}
+
+func (r RandomSuffixConflictResolver) RenameInstance(original string, attempt int, conflict probeConflict) string {
+	return fmt.Sprintf("%s-%s", original, randomSuffix(r.length()+attempt-1))
+}
+
+func (r RandomSuffixConflictResolver) length() int {
+	if r.Length > 0 {
+		return r.Length
+	}
+
+	return 4
+}
+
+const randomSuffixAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomSuffixRand is shared by every call to randomSuffix, instead of each
+// call seeding its own source from the clock: two interfaces renaming in
+// the same reconcile round, or two renames of the same candidate in quick
+// succession, can land in the same nanosecond and draw identical suffixes
+// from two freshly-seeded sources. A single, mutex-guarded source keeps
+// advancing across calls, so concurrent renames can't collide this way.
+var (
+	randomSuffixMu   sync.Mutex
+	randomSuffixRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randomSuffix(n int) string {
+	randomSuffixMu.Lock()
+	defer randomSuffixMu.Unlock()
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randomSuffixAlphabet[randomSuffixRand.Intn(len(randomSuffixAlphabet))]
+	}
+
+	return string(b)
+}
+
+// InterfaceMACSuffixConflictResolver renames by appending the last three
+// octets of a network interface's hardware address, producing a suffix that
+// is stable across restarts instead of a counter that keeps climbing.
+type InterfaceMACSuffixConflictResolver struct {
+	Iface *net.Interface
+}
+
+func (r InterfaceMACSuffixConflictResolver) RenameHost(original string, attempt int, conflict probeConflict) string {
+	return r.rename(original, attempt)
+}
+
+func (r InterfaceMACSuffixConflictResolver) RenameInstance(original string, attempt int, conflict probeConflict) string {
+	return r.rename(original, attempt)
+}
+
+func (r InterfaceMACSuffixConflictResolver) rename(original string, attempt int) string {
+	suffix := macSuffix(r.Iface)
+	if suffix == "" {
+		// No usable hardware address: fall back to the default behavior
+		// rather than producing an un-renamed, still-conflicting name.
+		return fmt.Sprintf("%s-%d", original, attempt+1)
+	}
+
+	return fmt.Sprintf("%s-%s", original, suffix)
+}
+
+func macSuffix(iface *net.Interface) string {
+	if iface == nil || len(iface.HardwareAddr) < 3 {
+		return ""
+	}
+
+	hw := iface.HardwareAddr
+	return fmt.Sprintf("%02x%02x%02x", hw[len(hw)-3], hw[len(hw)-2], hw[len(hw)-1])
+}
+
+// resolveProbeOptions returns the first ProbeOptions passed, or the zero
+// value (today's default behavior) if none was given, with a nil
+// ConflictResolver replaced by DefaultConflictResolver.
+func resolveProbeOptions(opts []ProbeOptions) ProbeOptions {
+	var o ProbeOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	if o.ConflictResolver == nil {
+		o.ConflictResolver = DefaultConflictResolver{}
+	}
+
+	return o
+}
+
 // ProbeService probes for the hostname and service instance name of srv.
 // If err == nil, the returned service is verified to be unique on the local network.
-func ProbeService(ctx context.Context, srv Service) (Service, error) {
+func ProbeService(ctx context.Context, srv Service, opts ...ProbeOptions) (Service, error) {
+	// Fail fast if this host cannot open a multicast socket at all, before
+	// committing to any per-interface probing below.
 	conn, err := newMDNSConn()
-
 	if err != nil {
 		return srv, err
 	}
-
-	defer conn.close()
+	conn.close()
 
 	// After one minute of probing, if the Multicast DNS responder has been
 	// unable to find any unused name, it should log an error (RFC6762 9)
@@ -35,37 +228,267 @@ func ProbeService(ctx context.Context, srv Service) (Service, error) {
 	log.Debug.Println("Probing delay", delay)
 	time.Sleep(delay)
 
-	return probeService(probeCtx, conn, srv, 1*time.Millisecond, false)
+	return probeService(probeCtx, srv, false, resolveProbeOptions(opts))
 }
 
-func ReprobeService(ctx context.Context, srv Service) (Service, error) {
-	conn, err := newMDNSConn()
+func ReprobeService(ctx context.Context, srv Service, opts ...ProbeOptions) (Service, error) {
+	resolved := resolveProbeOptions(opts)
 
+	// A NameCache has no notion of which claim it observed is this host's
+	// own: reprobing re-verifies a name srv already holds, so the cache's
+	// record of that very claim (from our own routine announcements, not
+	// necessarily a real competing host) would otherwise be mistaken for a
+	// conflict and force a needless rename. See ProbeOptions.NameCache.
+	if resolved.NameCache != nil {
+		return srv, fmt.Errorf("dnssd: ReprobeService does not support ProbeOptions.NameCache")
+	}
+
+	// Fail fast if this host cannot open a multicast socket at all, before
+	// committing to any per-interface probing below.
+	conn, err := newMDNSConn()
 	if err != nil {
 		return srv, err
 	}
+	conn.close()
 
-	defer conn.close()
-	return probeService(ctx, conn, srv, 1*time.Millisecond, true)
+	return probeService(ctx, srv, true, resolved)
+}
+
+// ifaceFamily distinguishes the IPv4 and IPv6 address views of a network
+// interface. A dual-stack interface gets one probe state machine per
+// family, the same way presotto/go-mdns-sd keeps a separate multicastIfc per
+// address family. Each of them still probes with all of the interface's A
+// and AAAA records; the records are not split by family.
+type ifaceFamily int
+
+const (
+	ifaceIPv4 ifaceFamily = iota
+	ifaceIPv6
+)
+
+// ifaceTarget is the unit of concurrency for probing: one network interface
+// and one address family. ips holds that family's addresses; probing does
+// not read family or ips.
+type ifaceTarget struct {
+	iface  *net.Interface
+	family ifaceFamily
+	ips    []net.IP
+}
+
+// ifaceTargets splits a service's interfaces into independent per-family
+// probe targets, skipping interfaces that can no longer be resolved.
+func ifaceTargets(service Service) []ifaceTarget {
+	var targets []ifaceTarget
+
+	for ifname, ips := range service.IfaceIPs {
+		iface, err := net.InterfaceByName(ifname)
+		if err != nil {
+			log.Debug.Printf("error getting interface with name %s: %v\n", ifname, err)
+			continue
+		}
+
+		var v4, v6 []net.IP
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				v4 = append(v4, ip)
+			} else {
+				v6 = append(v6, ip)
+			}
+		}
+
+		if len(v4) > 0 {
+			targets = append(targets, ifaceTarget{iface: iface, family: ifaceIPv4, ips: v4})
+		}
+		if len(v6) > 0 {
+			targets = append(targets, ifaceTarget{iface: iface, family: ifaceIPv6, ips: v6})
+		}
+	}
+
+	return targets
+}
+
+// ifaceProbeReport is sent by probeAtInterface once it has settled on a
+// candidate that is free of conflicts on its own interface/family.
+type ifaceProbeReport struct {
+	target  ifaceTarget
+	service Service
+	err     error
+
+	// hostAttempts and nameAttempts count the conflict-driven renames this
+	// interface applied to reach service, so probeService can tell which of
+	// several disagreeing interfaces saw the most constrained view.
+	hostAttempts int
+	nameAttempts int
+}
+
+// probeService runs one independent probe state machine per active
+// interface/family (see ifaceTargets), each with its own conflict cache and
+// rename counters, and only returns once every one of them has proven the
+// same candidate name unique. A conflict observed on one interface only
+// restarts that interface's own probing instead of aborting the whole
+// operation, so a collision on one link (e.g. a VPN) no longer forces an
+// unnecessary rename on a multi-homed host's other, conflict-free links.
+func probeService(ctx context.Context, srv Service, probeOnce bool, opts ProbeOptions) (s Service, e error) {
+	targets := ifaceTargets(srv)
+	if len(targets) == 0 {
+		s = srv
+		return
+	}
+
+	candidate := srv.Copy()
+	hostAttempts := 0
+	nameAttempts := 0
+
+	// Cap the number of reconcile rounds, the same way the original
+	// single-interface probeService capped its rename loop: a resolver that
+	// can't converge (or interfaces that keep disagreeing) must not spin
+	// forever, especially for ReprobeService, whose ctx carries no deadline
+	// of its own.
+	for round := 1; round <= 100; round++ {
+		reports := make(chan ifaceProbeReport, len(targets))
+
+		roundCtx, cancelRound := context.WithCancel(ctx)
+		for _, target := range targets {
+			go probeAtInterface(roundCtx, *candidate, target, probeOnce, opts, srv, hostAttempts, nameAttempts, reports)
+		}
+
+		results := make([]ifaceProbeReport, 0, len(targets))
+		for range targets {
+			report := <-reports
+			if report.err != nil {
+				cancelRound()
+				e = report.err
+				return
+			}
+			results = append(results, report)
+		}
+		cancelRound()
+
+		// Adopt whichever candidate required the most renaming: that is the
+		// most constrained view and every other interface must re-verify it
+		// before the whole service can converge.
+		winner := results[0]
+		agreed := true
+		for _, report := range results[1:] {
+			if !sameCandidate(report.service, winner.service) {
+				agreed = false
+			}
+			if report.hostAttempts+report.nameAttempts > winner.hostAttempts+winner.nameAttempts {
+				winner = report
+			}
+		}
+
+		if agreed {
+			s = winner.service
+			notifyRename(opts.OnRename, srv, s)
+			return
+		}
+
+		candidate = winner.service.Copy()
+		hostAttempts = winner.hostAttempts
+		nameAttempts = winner.nameAttempts
+	}
+
+	e = fmt.Errorf("could not agree on a name across interfaces for %s after 100 rounds", srv.Host)
+	return
+}
+
+// notifyRename calls onRename, if set, once for each of host and instance
+// name that changed between original and final - the converged result that
+// ProbeService or ReprobeService is about to return. It is called
+// synchronously from probeService's own goroutine, never concurrently from
+// the per-interface probe workers, so a callback that persists the chosen
+// name across restarts never sees an intermediate candidate that a
+// different interface later overruled.
+func notifyRename(onRename func(kind RenameKind, original string, renamed string), original Service, final Service) {
+	if onRename == nil {
+		return
+	}
+
+	if final.Host != original.Host {
+		onRename(RenameKindHost, original.Host, final.Host)
+	}
+	if final.Name != original.Name {
+		onRename(RenameKindInstance, original.Name, final.Name)
+	}
+}
+
+// sameCandidate reports whether two services propose the same hostname and
+// service instance name.
+func sameCandidate(a Service, b Service) bool {
+	return a.Host == b.Host && a.Name == b.Name
+}
+
+// skipProbe reports whether opts' name cache has been watching target's
+// interface long enough, and has not seen candidate's hostname or instance
+// name claimed there, to skip the three-query probe and go straight to the
+// announcement phase.
+func skipProbe(cache *NameCache, target ifaceTarget, candidate Service) bool {
+	if cache == nil || !cache.IsWarm() {
+		return false
+	}
+
+	ifaceName := target.iface.Name
+	return !cache.Contains(ifaceName, candidate.Hostname()) && !cache.Contains(ifaceName, candidate.ServiceInstanceName())
 }
 
-func probeService(ctx context.Context, conn MDNSConn, srv Service, delay time.Duration, probeOnce bool) (s Service, e error) {
+// probeAtInterface runs the full probe-and-rename loop for a single
+// interface/family, independently of every other interface. It reports the
+// first candidate that this interface alone finds free of conflicts.
+//
+// base is the original, never-renamed service, and baseHostAttempts/
+// baseNameAttempts are the cumulative rename counts carried in from earlier
+// reconcile rounds in probeService - srv is only this round's starting
+// candidate. Renaming always derives the next name from base with a
+// cumulative attempt count, so a field that keeps colliding across rounds
+// produces the usual "-2, -3, ..." progression instead of compounding onto
+// an already-renamed candidate.
+//
+// It opens its own MDNSConn rather than sharing one with the other
+// interfaces being probed concurrently: draining and reading a connection
+// is not safe to multiplex across goroutines, and a shared connection can
+// hand interface A's conflict to interface B's reader, which discards it as
+// not matching its own records - silently defeating the per-interface
+// conflict detection this function exists to provide.
+func probeAtInterface(ctx context.Context, srv Service, target ifaceTarget, probeOnce bool, opts ProbeOptions, base Service, baseHostAttempts int, baseNameAttempts int, out chan<- ifaceProbeReport) {
+	conn, err := newMDNSConn()
+	if err != nil {
+		out <- ifaceProbeReport{target: target, err: err}
+		return
+	}
+	defer conn.close()
+
 	candidate := srv.Copy()
 	prevConflict := probeConflict{}
+	delay := 1 * time.Millisecond
 
-	// Keep track of the number of conflicts
-	numHostConflicts := 0
-	numNameConflicts := 0
+	numHostConflicts := baseHostAttempts
+	numNameConflicts := baseNameAttempts
 
 	for i := 1; i <= 100; i++ {
-		conflict, err := probe(ctx, conn, *candidate)
+		if skipProbe(opts.NameCache, target, *candidate) {
+			out <- ifaceProbeReport{
+				target:       target,
+				service:      *candidate,
+				hostAttempts: numHostConflicts,
+				nameAttempts: numNameConflicts,
+			}
+			return
+		}
+
+		conflict, err := probeRoundAtInterface(ctx, conn, *candidate, target, opts)
 		if err != nil {
-			e = err
+			out <- ifaceProbeReport{target: target, err: err}
 			return
 		}
 
 		if conflict.hasNone() {
-			s = *candidate
+			out <- ifaceProbeReport{
+				target:       target,
+				service:      *candidate,
+				hostAttempts: numHostConflicts,
+				nameAttempts: numNameConflicts,
+			}
 			return
 		}
 
@@ -73,13 +496,13 @@ func probeService(ctx context.Context, conn MDNSConn, srv Service, delay time.Du
 
 		if conflict.hostname && (prevConflict.hostname || probeOnce) {
 			numHostConflicts++
-			candidate.Host = fmt.Sprintf("%s-%d", srv.Host, numHostConflicts+1)
+			candidate.Host = opts.ConflictResolver.RenameHost(base.Host, numHostConflicts, conflict)
 			conflict.hostname = false
 		}
 
 		if conflict.serviceName && (prevConflict.serviceName || probeOnce) {
 			numNameConflicts++
-			candidate.Name = fmt.Sprintf("%s-%d", srv.Name, numNameConflicts+1)
+			candidate.Name = opts.ConflictResolver.RenameInstance(base.Name, numNameConflicts, conflict)
 			conflict.serviceName = false
 		}
 
@@ -96,31 +519,27 @@ func probeService(ctx context.Context, conn MDNSConn, srv Service, delay time.Du
 		}
 
 		log.Debug.Println("Probing wait", delay)
-		time.Sleep(delay)
-	}
-
-	return
-}
-
-func probe(ctx context.Context, conn MDNSConn, service Service) (conflict probeConflict, err error) {
-	for ifname, ips := range service.IfaceIPs {
-		iface, err := net.InterfaceByName(ifname)
-		if err != nil {
-			log.Debug.Printf("error getting interface with name %s: %v\n", ifname, err)
-			continue
-		}
-		log.Debug.Printf("Probing with %v at %s\n", ips, iface.Name)
 
-		conflict, err := probeAtInterface(ctx, conn, service, iface)
-		if conflict.hasAny() {
-			return conflict, err
+		select {
+		case <-ctx.Done():
+			out <- ifaceProbeReport{target: target, err: ctx.Err()}
+			return
+		case <-time.After(delay):
 		}
 	}
 
-	return probeConflict{}, nil
+	out <- ifaceProbeReport{
+		target: target,
+		err:    fmt.Errorf("could not find a conflict-free name on %s after 100 attempts", target.iface.Name),
+	}
 }
 
-func probeAtInterface(ctx context.Context, conn MDNSConn, service Service, iface *net.Interface) (conflict probeConflict, err error) {
+// probeRoundAtInterface sends up to three probe queries for a single
+// candidate on a single interface/family and collects any conflicts seen in
+// response - four if opts.UnicastResponse is set, to keep two plain
+// multicast queries in the mix as a fallback against issue #15.
+func probeRoundAtInterface(ctx context.Context, conn MDNSConn, service Service, target ifaceTarget, opts ProbeOptions) (conflict probeConflict, err error) {
+	iface := target.iface
 
 	msg := new(dns.Msg)
 
@@ -136,11 +555,6 @@ func probeAtInterface(ctx context.Context, conn MDNSConn, service Service, iface
 		Qclass: dns.ClassINET,
 	}
 
-// Match fix for https://github.com/brutella/dnssd/issues/15 
-//	// Responses to probe should be unicast
-//	setQuestionUnicast(&instanceQ)
-//	setQuestionUnicast(&hostQ)
-
 	msg.Question = []dns.Question{instanceQ, hostQ}
 
 	srv := SRV(service)
@@ -167,58 +581,75 @@ func probeAtInterface(ctx context.Context, conn MDNSConn, service Service, iface
 	queryTime := time.After(1 * time.Millisecond)
 	queriesCount := 1
 
+	// Normally three probe queries are sent and the last one is always
+	// multicast (RFC6762 8.1). When UnicastResponse is enabled the first
+	// two of those are unicast-only, which would otherwise leave just a
+	// single plain-multicast query to catch a responder that ignores the
+	// QU bit and never replies at all - the exact failure mode behind
+	// issue #15. Send one extra query in that case, so unicast mode still
+	// has two plain-multicast queries (the default path sends three).
+	totalQueries := 3
+	if opts.UnicastResponse {
+		totalQueries = 4
+	}
+
+	hostName := strings.ToLower(service.Hostname())
+	instanceName := strings.ToLower(service.ServiceInstanceName())
+	hostAuthority := authority[1:] // everything but the leading SRV record
+	instanceAuthority := authority[:1]
+
+	// If opts.NameCache is set, it snoops multicast traffic independently of
+	// this probe round. Poll it often enough that a name it sees claimed
+	// counts as a conflict right away, instead of only once the next
+	// 250ms query tick happens to notice the same traffic.
+	var cacheTick <-chan time.Time
+	if opts.NameCache != nil {
+		ticker := time.NewTicker(25 * time.Millisecond)
+		defer ticker.Stop()
+		cacheTick = ticker.C
+	}
+
 	for {
 		select {
+		case <-cacheTick:
+			if opts.NameCache.Contains(iface.Name, hostName) {
+				conflict.hostname = true
+			}
+			if opts.NameCache.Contains(iface.Name, instanceName) {
+				conflict.serviceName = true
+			}
+
 		case req := <-ch:
-			answers := allRecords(req.msg)
-			for _, answer := range answers {
-				switch rr := answer.(type) {
-				case *dns.A:
-					for _, a := range as {
-						if isDenyingA(rr, a) {
-							/*
-							fmt.Printf("DENIES A req: %#v\n",req)
-							if req.from == nil {
-								fmt.Printf("DENIES A req.from NIL\n")
-							}
-							if req.iface == nil {
-								fmt.Printf("DENIES A req.iface NIL\n")
-							}
-							log.Debug.Printf("%v:%d@%s denies A\n", req.from.IP, req.from.Port, req.iface.Name)
-							*/
-							conflict.hostname = true
-							break
-						}
-					}
-
-				case *dns.AAAA:
-					for _, aaaa := range aaaas {
-						if isDenyingAAAA(rr, aaaa) {
-							/*
-							fmt.Printf("DENIES AAAA req: %#v\n",req)
-							if req.from == nil {
-								fmt.Printf("DENIES AAAA req.from NIL\n")
-							}
-							if req.iface == nil {
-								fmt.Printf("DENIES AAAA req.iface NIL\n")
-							}
-							log.Debug.Printf("%v:%d@%s denies AAAA\n", req.from.IP, req.from.Port, req.iface.Name)
-							*/
-							conflict.hostname = true
-							break
-						}
-					}
-
-				case *dns.SRV:
-					if isDenyingSRV(rr, srv) {
-						conflict.serviceName = true
-					}
+			// This conn is shared by every interface's mDNS group
+			// membership, so it delivers traffic seen on every interface,
+			// not just target's. A conflict on another link must not
+			// constrain this one.
+			if req.iface != nil && req.iface.Name != iface.Name {
+				continue
+			}
 
+			// RFC6762 8.2 tiebreaking compares the *entire* record set for a
+			// name, not one record at a time, so group every A/AAAA/SRV
+			// answer in the response by the name it belongs to first.
+			peerRRs := map[string][]dns.RR{}
+			for _, answer := range allRecords(req.msg) {
+				switch answer.(type) {
+				case *dns.A, *dns.AAAA, *dns.SRV:
+					name := strings.ToLower(answer.Header().Name)
+					peerRRs[name] = append(peerRRs[name], answer)
 				default:
-					break
+					continue
 				}
 			}
 
+			if peer, ok := peerRRs[hostName]; ok && tiebreakDenies(peer, hostAuthority) {
+				conflict.hostname = true
+			}
+
+			if peer, ok := peerRRs[instanceName]; ok && tiebreakDenies(peer, instanceAuthority) {
+				conflict.serviceName = true
+			}
+
 		case <-ctx.Done():
 			err = ctx.Err()
 			return
@@ -229,11 +660,25 @@ func probeAtInterface(ctx context.Context, conn MDNSConn, service Service, iface
 				return
 			}
 
-			// Stop after 3 probe queries
-			if queriesCount > 3 {
+			// Stop once every probe query has been sent
+			if queriesCount > totalQueries {
 				return
 			}
 
+			// The first two probe queries SHOULD request a unicast response,
+			// so responders don't need to multicast their answer to the
+			// whole network; every query after that is always multicast, so
+			// at least two queries still reach responders that ignore the
+			// QU bit or whose unicast reply doesn't make it back to us.
+			// (RFC6762 5.4, 8.1)
+			if opts.UnicastResponse && queriesCount <= 2 {
+				setQuestionUnicast(&msg.Question[0])
+				setQuestionUnicast(&msg.Question[1])
+			} else {
+				clearQuestionUnicast(&msg.Question[0])
+				clearQuestionUnicast(&msg.Question[1])
+			}
+
 			queriesCount++
 			log.Debug.Println("Sending probe", msg)
 			q := &Query{msg: msg, iface: iface}
@@ -248,6 +693,21 @@ func probeAtInterface(ctx context.Context, conn MDNSConn, service Service, iface
 	return
 }
 
+// setQuestionUnicast sets the top bit of the question class, asking the
+// responder to reply via unicast rather than multicast (RFC6762 5.4). conn.Read
+// merges unicast replies with multicast ones onto the same channel, so
+// probeRoundAtInterface doesn't need to treat them differently once they
+// arrive.
+func setQuestionUnicast(q *dns.Question) {
+	q.Qclass |= 1 << 15
+}
+
+// clearQuestionUnicast undoes setQuestionUnicast, restoring a plain
+// multicast question.
+func clearQuestionUnicast(q *dns.Question) {
+	q.Qclass &^= 1 << 15
+}
+
 type probeConflict struct {
 	hostname    bool
 	serviceName bool
@@ -261,136 +721,128 @@ func (pr probeConflict) hasAny() bool {
 	return pr.hostname || pr.serviceName
 }
 
-func isDenyingA(this *dns.A, that *dns.A) bool {
-	if strings.EqualFold(this.Hdr.Name, that.Hdr.Name) {
-		log.Debug.Println("Conflicting hosts")
-
-		if !isValidRR(this) {
+// tiebreakDenies implements RFC6762 8.2's simultaneous-probe tiebreak over a
+// full record set: ourRRs is our own proposed authority records for a name,
+// peerRRs is every record the peer sent back for that same name. It reports
+// whether the peer's canonicalized, sorted record set sorts later than ours
+// - meaning the peer wins and we must defer and re-probe. An identical
+// record set is not a real conflict at all; one containing an invalid
+// record always denies, regardless of ordering.
+func tiebreakDenies(peerRRs []dns.RR, ourRRs []dns.RR) bool {
+	for _, rr := range peerRRs {
+		if !isValidRR(rr) {
 			log.Debug.Println("Invalid record produces conflict")
 			return true
 		}
-
-		switch compareIP(this.A.To4(), that.A.To4()) {
-		case -1:
-			log.Debug.Println("Lexicographical earlier")
-			break
-		case 1:
-			log.Debug.Println("Lexicographical later")
-			return true
-		default:
-			log.Debug.Println("Tiebreak")
-			break
-		}
 	}
 
-	return false
-}
-
-// isDenyingAAAA returns true if this denies that.
-func isDenyingAAAA(this *dns.AAAA, that *dns.AAAA) bool {
-	if strings.EqualFold(this.Hdr.Name, that.Hdr.Name) {
-		log.Debug.Println("Conflicting hosts")
-		if !isValidRR(this) {
-			log.Debug.Println("Invalid record produces conflict")
-			return true
-		}
-
-		switch compareIP(this.AAAA.To16(), that.AAAA.To16()) {
-		case -1:
-			log.Debug.Println("Lexicographical earlier")
-			break
-		case 1:
-			log.Debug.Println("Lexicographical later")
-			return true
-		default:
-			log.Debug.Println("Tiebreak")
-			break
-		}
+	switch compareRRSets(canonicalRRSet(peerRRs), canonicalRRSet(ourRRs)) {
+	case -1:
+		log.Debug.Println("Lexicographical earlier")
+		return false
+	case 1:
+		log.Debug.Println("Lexicographical later")
+		return true
+	default:
+		log.Debug.Println("Tiebreak: identical record sets")
+		return false
 	}
-
-	return false
 }
 
-// isDenyingSRV returns true if this denies that.
-func isDenyingSRV(this *dns.SRV, that *dns.SRV) bool {
-	if strings.EqualFold(this.Hdr.Name, that.Hdr.Name) {
-		log.Debug.Println("Conflicting SRV")
-		if !isValidRR(this) {
-			log.Debug.Println("Invalid record produces conflict")
-			return true
-		}
+// canonicalRR renders rr the way RFC6762 8.2 compares it: owner name
+// lowercased, class and type in network order, followed by the rdata in
+// wire form.
+func canonicalRR(rr dns.RR) []byte {
+	h := rr.Header()
 
-		switch compareSRV(this, that) {
-		case -1:
-			log.Debug.Println("Lexicographical earlier")
-			break
-		case 1:
-			log.Debug.Println("Lexicographical later")
-			return true
-		default:
-			log.Debug.Println("Tiebreak")
-			break
-		}
-	}
+	buf := wireName(h.Name)
 
-	return false
-}
+	var classType [4]byte
+	binary.BigEndian.PutUint16(classType[0:2], h.Class)
+	binary.BigEndian.PutUint16(classType[2:4], h.Rrtype)
+	buf = append(buf, classType[:]...)
 
-func isValidRR(rr dns.RR) bool {
 	switch r := rr.(type) {
 	case *dns.A:
-		return !net.IPv4zero.Equal(r.A)
+		buf = append(buf, r.A.To4()...)
 	case *dns.AAAA:
-		return !net.IPv6zero.Equal(r.AAAA)
+		buf = append(buf, r.AAAA.To16()...)
 	case *dns.SRV:
-		return len(r.Target) > 0 && r.Port != 0
-	default:
-		break
+		var srv [6]byte
+		binary.BigEndian.PutUint16(srv[0:2], r.Priority)
+		binary.BigEndian.PutUint16(srv[2:4], r.Weight)
+		binary.BigEndian.PutUint16(srv[4:6], r.Port)
+		buf = append(buf, srv[:]...)
+		buf = append(buf, wireName(r.Target)...)
 	}
 
-	return true
+	return buf
 }
 
-func compareIP(this net.IP, that net.IP) int {
-	count := len(this)
-	if count > len(that) {
-		count = len(that)
+// wireName renders a domain name the way RFC6762 8.2 compares it:
+// lowercased and in uncompressed wire form (a sequence of length-prefixed
+// labels), rather than as dotted text, so that two names differing only in
+// label boundaries or trailing dots still compare identically to an
+// RFC-compliant peer.
+func wireName(name string) []byte {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+
+	buf := make([]byte, 0, len(name)+1)
+	for _, label := range labels {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
 	}
+	buf = append(buf, 0)
 
-	for i := 0; i < count; i++ {
-		if this[i] < that[i] {
-			return -1
-		} else if this[i] > that[i] {
-			return 1
-		}
-	}
+	return buf
+}
 
-	if len(this) < len(that) {
-		return -1
-	} else if len(this) > len(that) {
-		return 1
+// canonicalRRSet canonicalizes and sorts rrs, per RFC6762 8.2.
+func canonicalRRSet(rrs []dns.RR) [][]byte {
+	set := make([][]byte, len(rrs))
+	for i, rr := range rrs {
+		set[i] = canonicalRR(rr)
 	}
-	return 0
+
+	sort.Slice(set, func(i, j int) bool {
+		return bytes.Compare(set[i], set[j]) < 0
+	})
+
+	return set
 }
 
-func compareSRV(this *dns.SRV, that *dns.SRV) int {
-	if this.Priority < that.Priority {
-		return -1
-	} else if this.Priority > that.Priority {
-		return 1
+// compareRRSets compares two already-canonicalized, sorted record sets
+// byte-by-byte, record by record, the way RFC6762 8.2 compares the
+// authority sections of two simultaneous probes.
+func compareRRSets(a [][]byte, b [][]byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := bytes.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
 	}
 
-	if this.Weight < that.Weight {
+	switch {
+	case len(a) < len(b):
 		return -1
-	} else if this.Weight > that.Weight {
+	case len(a) > len(b):
 		return 1
+	default:
+		return 0
 	}
+}
 
-	if this.Port < that.Port {
-		return -1
-	} else if this.Port > that.Port {
-		return 1
+func isValidRR(rr dns.RR) bool {
+	switch r := rr.(type) {
+	case *dns.A:
+		return !net.IPv4zero.Equal(r.A)
+	case *dns.AAAA:
+		return !net.IPv6zero.Equal(r.AAAA)
+	case *dns.SRV:
+		return len(r.Target) > 0 && r.Port != 0
+	default:
+		break
 	}
 
-	return strings.Compare(this.Target, that.Target)
+	return true
 }
+
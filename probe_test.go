@@ -0,0 +1,83 @@
+package dnssd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func aRecord(name string, ip string) *dns.A {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   net.ParseIP(ip).To4(),
+	}
+}
+
+func aaaaRecord(name string, ip string) *dns.AAAA {
+	return &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET},
+		AAAA: net.ParseIP(ip),
+	}
+}
+
+func srvRecord(name string, target string, port uint16) *dns.SRV {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+		Priority: 0,
+		Weight:   0,
+		Port:     port,
+		Target:   target,
+	}
+}
+
+func TestTiebreakDeniesEarlier(t *testing.T) {
+	ours := []dns.RR{
+		aRecord("Host.local.", "169.254.1.2"),
+		aaaaRecord("Host.local.", "fe80::2"),
+		srvRecord("My Instance._http._tcp.local.", "host.local.", 8080),
+	}
+	peer := []dns.RR{
+		aRecord("Host.local.", "169.254.1.1"),
+		aaaaRecord("Host.local.", "fe80::1"),
+		srvRecord("My Instance._http._tcp.local.", "host.local.", 8080),
+	}
+
+	if tiebreakDenies(peer, ours) {
+		t.Fatal("expected the lexicographically earlier peer record set not to deny")
+	}
+}
+
+func TestTiebreakDeniesLater(t *testing.T) {
+	ours := []dns.RR{
+		aRecord("Host.local.", "169.254.1.1"),
+		aaaaRecord("Host.local.", "fe80::1"),
+		srvRecord("My Instance._http._tcp.local.", "host.local.", 8080),
+	}
+	peer := []dns.RR{
+		aRecord("Host.local.", "169.254.1.2"),
+		aaaaRecord("Host.local.", "fe80::2"),
+		srvRecord("My Instance._http._tcp.local.", "host.local.", 8080),
+	}
+
+	if !tiebreakDenies(peer, ours) {
+		t.Fatal("expected the lexicographically later peer record set to deny")
+	}
+}
+
+func TestTiebreakDeniesTie(t *testing.T) {
+	ours := []dns.RR{
+		aRecord("Host.local.", "169.254.1.1"),
+		aaaaRecord("Host.local.", "fe80::1"),
+		srvRecord("My Instance._http._tcp.local.", "host.local.", 8080),
+	}
+	peer := []dns.RR{
+		srvRecord("My Instance._http._tcp.local.", "HOST.LOCAL.", 8080),
+		aaaaRecord("HOST.local.", "fe80::1"),
+		aRecord("host.LOCAL.", "169.254.1.1"),
+	}
+
+	if tiebreakDenies(peer, ours) {
+		t.Fatal("expected an identical (case-insensitively, regardless of order) record set not to deny")
+	}
+}